@@ -0,0 +1,76 @@
+package main
+
+import "fmt"
+
+// ChunkPolicy decides whether a file size looks like a fragment of an
+// aborted chunked upload, so deatomize knows to look for a prior version to
+// roll back to instead of trusting the current file's content.
+type ChunkPolicy interface {
+	IsChunked(size uint64) bool
+}
+
+// ownCloudChunkSize is the fixed chunk size the ownCloud desktop client has
+// always used; unlike Nextcloud it isn't negotiated with the server, so
+// ownCloudChunkPolicy ignores -chunk-size and hardcodes it.
+const ownCloudChunkSize = 10 * 1000000
+
+// ownCloudChunkPolicy matches the ownCloud desktop client.
+type ownCloudChunkPolicy struct {
+	minChunkedSize uint64
+}
+
+func (p *ownCloudChunkPolicy) IsChunked(size uint64) bool {
+	return isMultipleAbove(size, ownCloudChunkSize, p.minChunkedSize)
+}
+
+// nextcloudChunkPolicy matches Nextcloud clients, whose chunk size is not
+// fixed: it is whatever the server advertised in its capabilities response
+// at upload time, so it comes from -chunk-size rather than a hardcoded
+// constant.
+type nextcloudChunkPolicy struct {
+	chunkSize      uint64
+	minChunkedSize uint64
+}
+
+func (p *nextcloudChunkPolicy) IsChunked(size uint64) bool {
+	return isMultipleAbove(size, p.chunkSize, p.minChunkedSize)
+}
+
+// multipleOfChunkPolicy is the generic fallback for any client that chunks
+// uploads into fixed-size pieces whose size isn't known ahead of time; like
+// nextcloudChunkPolicy it takes chunkSize from -chunk-size.
+type multipleOfChunkPolicy struct {
+	chunkSize      uint64
+	minChunkedSize uint64
+}
+
+func (p *multipleOfChunkPolicy) IsChunked(size uint64) bool {
+	return isMultipleAbove(size, p.chunkSize, p.minChunkedSize)
+}
+
+// isMultipleAbove is the shared rule behind every policy above: size is
+// chunked if it's an exact multiple of chunkSize and at least
+// minChunkedSize, the latter to avoid false positives on small legitimate
+// files whose size happens to be a multiple of the chunk size.
+func isMultipleAbove(size, chunkSize, minChunkedSize uint64) bool {
+	if chunkSize == 0 || size < minChunkedSize {
+		return false
+	}
+	return size%chunkSize == 0
+}
+
+// newChunkPolicy builds the ChunkPolicy named by -chunk-policy ("owncloud",
+// the default, "nextcloud" or "multiple"), using chunkSize and
+// minChunkedSize (both in bytes).
+func newChunkPolicy(policy string, chunkSize, minChunkedSize uint64) (ChunkPolicy, error) {
+	switch policy {
+	case "owncloud", "":
+		return &ownCloudChunkPolicy{minChunkedSize: minChunkedSize}, nil
+	case "nextcloud":
+		return &nextcloudChunkPolicy{chunkSize: chunkSize, minChunkedSize: minChunkedSize}, nil
+	case "multiple":
+		return &multipleOfChunkPolicy{chunkSize: chunkSize, minChunkedSize: minChunkedSize}, nil
+	default:
+		return nil, fmt.Errorf("unknown -chunk-policy %q, want owncloud, nextcloud or multiple", policy)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestIsMultipleAbove(t *testing.T) {
+	cases := []struct {
+		name           string
+		size           uint64
+		chunkSize      uint64
+		minChunkedSize uint64
+		want           bool
+	}{
+		{"multiple above minimum", 20000000, 10000000, 10000000, true},
+		{"multiple below minimum", 10000000, 10000000, 20000000, false},
+		{"not a multiple", 15000000, 10000000, 10000000, false},
+		{"zero chunk size", 20000000, 0, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isMultipleAbove(c.size, c.chunkSize, c.minChunkedSize); got != c.want {
+				t.Errorf("isMultipleAbove(%d, %d, %d) = %v, want %v", c.size, c.chunkSize, c.minChunkedSize, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNewChunkPolicy(t *testing.T) {
+	p, err := newChunkPolicy("owncloud", 0, 0)
+	if err != nil {
+		t.Fatalf("newChunkPolicy(owncloud): %v", err)
+	}
+	if !p.IsChunked(ownCloudChunkSize) {
+		t.Errorf("ownCloudChunkPolicy should ignore chunkSize and use the fixed 10MB size")
+	}
+	if p.IsChunked(12345678) {
+		t.Errorf("ownCloudChunkPolicy should not flag a size that isn't a multiple of 10MB")
+	}
+
+	p, err = newChunkPolicy("nextcloud", 4000000, 0)
+	if err != nil {
+		t.Fatalf("newChunkPolicy(nextcloud): %v", err)
+	}
+	if !p.IsChunked(8000000) || p.IsChunked(ownCloudChunkSize) {
+		t.Errorf("nextcloudChunkPolicy should honor -chunk-size, not the ownCloud constant")
+	}
+
+	p, err = newChunkPolicy("multiple", 7, 0)
+	if err != nil {
+		t.Fatalf("newChunkPolicy(multiple): %v", err)
+	}
+	if !p.IsChunked(14) || p.IsChunked(15) {
+		t.Errorf("multipleOfChunkPolicy should match any multiple of -chunk-size")
+	}
+
+	if _, err := newChunkPolicy("bogus", 0, 0); err == nil {
+		t.Errorf("newChunkPolicy(bogus) should return an error")
+	}
+}
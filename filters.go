@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// FilterStats reports how many records a filtering pass dropped, for the
+// summary line printed by main.
+type FilterStats struct {
+	Whitelisted int
+	Blacklisted int
+	PathPrefix  int
+}
+
+// fxidSet loads a comma-separated list and, if file is non-empty, a file of
+// one FXID per line, into a single set of FXIDs.
+func fxidSet(commaList, file string) (map[string]bool, error) {
+	set := map[string]bool{}
+	for _, id := range strings.Split(commaList, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			set[id] = true
+		}
+	}
+
+	if file == "" {
+		return set, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id != "" {
+			set[id] = true
+		}
+	}
+	return set, scanner.Err()
+}
+
+// filterByFXID restricts records to -fxid-whitelist/-fxid-file (if either is
+// set) and drops anything named in -fxid-blacklist. It runs right after
+// getRecords and before skipRecords, so operators can re-run just a
+// previously-nasty subset of FXIDs without regenerating the input CSV.
+func filterByFXID(records []*record) ([]*record, *FilterStats, error) {
+	st := &FilterStats{}
+
+	whitelist, err := fxidSet(*fxidWhitelist, *fxidFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	blacklist, err := fxidSet(*fxidBlacklist, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var toret []*record
+	for _, r := range records {
+		if len(whitelist) > 0 && !whitelist[r.FXID] {
+			st.Whitelisted++
+			continue
+		}
+		if len(blacklist) > 0 && blacklist[r.FXID] {
+			st.Blacklisted++
+			continue
+		}
+		toret = append(toret, r)
+	}
+	return toret, st, nil
+}
+
+// filterByPathPrefix drops records whose resolved EOS path does not start
+// with -path-prefix, e.g. to confine a repair pass to /eos/user/a/... . It
+// must run after skipRecords, since r.File is only populated once the MGM
+// has been consulted for each FXID.
+func filterByPathPrefix(records []*record, st *FilterStats) []*record {
+	if *pathPrefix == "" {
+		return records
+	}
+
+	var toret []*record
+	for _, r := range records {
+		if !strings.HasPrefix(r.File, *pathPrefix) {
+			st.PathPrefix++
+			continue
+		}
+		toret = append(toret, r)
+	}
+	return toret
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFxidSet(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fxids.txt")
+	if err := os.WriteFile(file, []byte("cccc\n\nbbbb\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	set, err := fxidSet("aaaa, bbbb", file)
+	if err != nil {
+		t.Fatalf("fxidSet: %v", err)
+	}
+	want := map[string]bool{"aaaa": true, "bbbb": true, "cccc": true}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("fxidSet = %v, want %v", set, want)
+	}
+}
+
+func TestFxidSetNoFile(t *testing.T) {
+	set, err := fxidSet("aaaa,,bbbb", "")
+	if err != nil {
+		t.Fatalf("fxidSet: %v", err)
+	}
+	want := map[string]bool{"aaaa": true, "bbbb": true}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("fxidSet = %v, want %v", set, want)
+	}
+}
+
+func TestFilterByFXID(t *testing.T) {
+	origWhitelist, origFile, origBlacklist := *fxidWhitelist, *fxidFile, *fxidBlacklist
+	defer func() {
+		*fxidWhitelist, *fxidFile, *fxidBlacklist = origWhitelist, origFile, origBlacklist
+	}()
+
+	*fxidWhitelist = "aaaa,bbbb"
+	*fxidFile = ""
+	*fxidBlacklist = "bbbb"
+
+	records := []*record{{FXID: "aaaa"}, {FXID: "bbbb"}, {FXID: "cccc"}}
+	toret, st, err := filterByFXID(records)
+	if err != nil {
+		t.Fatalf("filterByFXID: %v", err)
+	}
+	if len(toret) != 1 || toret[0].FXID != "aaaa" {
+		t.Errorf("filterByFXID result = %v, want only aaaa", toret)
+	}
+	if st.Whitelisted != 1 || st.Blacklisted != 1 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestFilterByPathPrefix(t *testing.T) {
+	orig := *pathPrefix
+	defer func() { *pathPrefix = orig }()
+	*pathPrefix = "/eos/user/a"
+
+	records := []*record{{File: "/eos/user/a/foo"}, {File: "/eos/user/b/foo"}}
+	st := &FilterStats{}
+	toret := filterByPathPrefix(records, st)
+	if len(toret) != 1 || toret[0].File != "/eos/user/a/foo" {
+		t.Errorf("filterByPathPrefix result = %v, want only the /eos/user/a file", toret)
+	}
+	if st.PathPrefix != 1 {
+		t.Errorf("expected PathPrefix stat of 1, got %d", st.PathPrefix)
+	}
+}
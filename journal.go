@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// JournalEntry is the durable, per-FXID record of repair progress. It is
+// appended to the journal file as a JSON line so an interrupted run can be
+// resumed without redoing work that already succeeded.
+type JournalEntry struct {
+	FXID              string `json:"fxid"`
+	Status            status `json:"status"`
+	ValidVersion      string `json:"valid_version,omitempty"`
+	RollbackAttempted bool   `json:"rollback_attempted"`
+	RollbackSucceeded bool   `json:"rollback_succeeded"`
+}
+
+// Journal is a JSON-lines append-only log of JournalEntry values keyed by
+// FXID, used to make a run resumable: getRecords merges the input CSV with
+// the journal and skips records whose rollback already succeeded.
+type Journal struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]*JournalEntry
+}
+
+// OpenJournal opens (creating if necessary) the journal file at path and
+// replays it into memory. An empty path disables journaling: the returned
+// Journal is fully functional but keeps no entries and persists nothing,
+// so callers don't need to special-case "-state not set".
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path, entries: map[string]*JournalEntry{}}
+	if path == "" {
+		return j, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	var goodOffset int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var e JournalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			// a crash mid-write can leave a truncated last line; stop
+			// replaying here instead of trusting anything past it
+			break
+		}
+		entry := e
+		j.entries[e.FXID] = &entry
+		goodOffset += int64(len(line)) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// drop any dangling partial line a crash left behind, so the next
+	// Record() appends after a clean newline instead of merging onto it
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() < goodOffset {
+		// the last replayed line lost only its newline; put it back
+		if _, err := f.WriteAt([]byte{'\n'}, goodOffset-1); err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else if err := f.Truncate(goodOffset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(goodOffset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	j.file = f
+	return j, nil
+}
+
+// Get returns the journal entry for fxid, if any.
+func (j *Journal) Get(fxid string) (*JournalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[fxid]
+	return e, ok
+}
+
+// Record persists the current state of r, overwriting any earlier entry for
+// the same FXID. It is written synchronously so the journal stays accurate
+// even if the process is killed immediately after.
+func (j *Journal) Record(r *record) {
+	e := &JournalEntry{
+		FXID:              r.FXID,
+		Status:            r.Status,
+		RollbackAttempted: r.RollbackAttempted,
+		RollbackSucceeded: r.RollbackSucceeded,
+	}
+	if r.ValidVersion != nil {
+		e.ValidVersion = r.ValidVersion.File
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[r.FXID] = e
+
+	if j.file == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	j.file.Write(data)
+}
+
+// Close flushes and closes the underlying journal file, if any.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenJournalReplaysEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	j.Record(&record{FXID: "aaaa", Status: repairable, RollbackSucceeded: true})
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	j2, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("reopen OpenJournal: %v", err)
+	}
+	e, ok := j2.Get("aaaa")
+	if !ok {
+		t.Fatalf("expected entry for aaaa after replay")
+	}
+	if e.Status != repairable || !e.RollbackSucceeded {
+		t.Fatalf("unexpected replayed entry: %+v", e)
+	}
+}
+
+func TestOpenJournalTruncatesDanglingLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	if err := os.WriteFile(path, []byte(`{"fxid":"aaaa","status":1,"rollback_succeeded":true}`+"\n"+`{"fxid":"bbbb","sta`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	if _, ok := j.Get("bbbb"); ok {
+		t.Fatalf("dangling truncated entry should not have been replayed")
+	}
+	if _, ok := j.Get("aaaa"); !ok {
+		t.Fatalf("expected aaaa to survive replay")
+	}
+
+	j.Record(&record{FXID: "cccc", Status: notNasty})
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	j2, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("reopen OpenJournal: %v", err)
+	}
+	if _, ok := j2.Get("cccc"); !ok {
+		t.Fatalf("entry recorded after a truncated reopen must survive the next reopen")
+	}
+	if _, ok := j2.Get("aaaa"); !ok {
+		t.Fatalf("expected aaaa to still be present")
+	}
+	if _, ok := j2.Get("bbbb"); ok {
+		t.Fatalf("dangling truncated entry must never resurface")
+	}
+}
@@ -7,14 +7,17 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"os"
+	"os/signal"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cs3org/reva/pkg/eosclient"
+	"github.com/sirupsen/logrus"
 )
 
 // status tells if a record is a nasty one and the reason why
@@ -35,7 +38,7 @@ func (s status) String() string {
 	case nastyNotExistsAnymore:
 		return "the_current_file_does_not_exists_anymore"
 	default:
-		log.Fatal("invalid state")
+		logger.Fatal("invalid state")
 		return "invalid record state"
 	}
 }
@@ -51,7 +54,8 @@ const (
 )
 
 var (
-	ctx    = context.Background()
+	ctx    context.Context
+	cancel context.CancelFunc
 	mgm    = flag.String("mgm", "root://eoshome.cern.ch", "mgm url")
 	user   = flag.String("user", "root", "user rol to execute against MGM")
 	group  = flag.String("group", "root", "group rol to execute against MGM")
@@ -66,8 +70,35 @@ var (
 	// 1592324335 018edba3
 	// where the separator is whitespace
 	file = flag.String("file", "./deatomize", "file containing files to deatomize")
+
+	workers     = flag.Int("workers", 8, "number of worker goroutines used to talk to the MGM")
+	concurrency = flag.Int("concurrency", 8, "max number of simultaneous calls to the MGM, across all workers")
+	rateLimit   = flag.Float64("rate-limit", 0, "max MGM calls/sec across all workers, 0 disables throttling")
+
+	state = flag.String("state", "", "path to a JSON-lines journal used to resume an interrupted run; disabled if empty")
+
+	fxidWhitelist = flag.String("fxid-whitelist", "", "comma-separated FXIDs to restrict analyze/rollback to")
+	fxidFile      = flag.String("fxid-file", "", "file with one FXID per line to restrict analyze/rollback to")
+	fxidBlacklist = flag.String("fxid-blacklist", "", "comma-separated FXIDs to exclude from analyze/rollback")
+	pathPrefix    = flag.String("path-prefix", "", "only process records whose EOS path has this prefix")
+
+	logFormat = flag.String("log-format", "text", "log output format: json or text")
+	logLevel  = flag.String("log-level", "info", "log level: debug, info, warn, error")
+
+	chunkSizeFlag   = flag.Uint64("chunk-size", 10*1000000, "chunk size in bytes used by the nextcloud and multiple chunk policies; owncloud always uses 10MB")
+	chunkPolicyFlag = flag.String("chunk-policy", "owncloud", "chunk detection policy: owncloud, nextcloud, or multiple")
+	minChunkedSize  = flag.Uint64("min-chunked-size", 10*1000000, "minimum size, in bytes, for a multiple of -chunk-size to be treated as chunked")
+
+	recoveryOrder = flag.String("recovery-order", "version,recycle", "comma-separated preference order for nasty-record recovery: version, recycle")
+
+	output     = flag.String("output", "text", "format for the per-record plan: json, csv or text")
+	reportPath = flag.String("report", "", "path to write a JSON summary report of the planned/performed recovery; disabled if empty")
 )
 
+// chunkPolicy is resolved from -chunk-policy/-chunk-size/-min-chunked-size in
+// main and consulted by isChunked.
+var chunkPolicy ChunkPolicy
+
 type skipped struct {
 	Recycle  int
 	Versions int
@@ -77,46 +108,105 @@ type skipped struct {
 func skipRecords(records []*record) (toret []*record, sk *skipped) {
 	sk = &skipped{}
 	client := getEosClient()
-	for _, r := range records {
+	var mu sync.Mutex
+
+	wq := NewWorkQueue(*workers, *concurrency, *rateLimit, func(r *record) error {
+		fields := logrus.Fields{"fxid": r.FXID, "action": "get_file_info"}
 		fi, err := client.GetFileInfoByFXID(ctx, *user, *group, r.FXID)
 		if err != nil {
-			fmt.Printf("error getting md from EOS: %+v\n", err)
-			continue
+			logger.WithFields(fields).WithError(err).Error("error getting md from EOS")
+			return err
 		}
 
 		// check that file is under a nominal space, not under proc recycle or versions folder
 		filename := fi.File
+		fields["file"] = filename
+		fields["size"] = fi.Size
+
+		mu.Lock()
+		defer mu.Unlock()
 		if strings.Contains(filename, "/proc/recycle") {
 			sk.Recycle++
-			fmt.Printf("skip: file is in recycle: %+v\n", fi)
-			continue
+			logger.WithFields(fields).WithField("status", "skip_recycle").Info("skip: file is in recycle")
+			return nil
 
 		} else if strings.Contains(filename, "sys.v") {
 			sk.Versions++
-			fmt.Printf("skip: file is a version: %+v\n", fi)
-			continue
+			logger.WithFields(fields).WithField("status", "skip_version").Info("skip: file is a version")
+			return nil
 
 		} else if strings.Contains(filename, "sys.a") {
 			sk.Atomic++
-			fmt.Printf("skip: file is atomic: %+v\n", fi)
-			continue
+			logger.WithFields(fields).WithField("status", "skip_atomic").Info("skip: file is atomic")
+			return nil
 		}
 
 		r.File = filename
+		r.Size = fi.Size
 		toret = append(toret, r)
-	}
+		return nil
+	})
+	wq.Run(records)
+
 	return toret, sk
 }
 
 func main() {
 	flag.Parse()
 
-	// get the records from the CSV white-space separated file.
-	records := getRecords(*file)
+	if err := initLogger(*logFormat, *logLevel); err != nil {
+		logger.Fatal(err)
+	}
+
+	var err error
+	chunkPolicy, err = newChunkPolicy(*chunkPolicyFlag, *chunkSizeFlag, *minChunkedSize)
+	if err != nil {
+		logger.WithError(err).Fatal("invalid chunk policy")
+	}
+
+	// cancel ctx on SIGINT so in-flight workers wind down and the journal
+	// gets flushed instead of leaving EOS in an unknown state.
+	ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	journal, err := OpenJournal(*state)
+	if err != nil {
+		logger.WithError(err).Fatal("could not open journal")
+	}
+	defer journal.Close()
+
+	// get the records from the CSV white-space separated file, merged with
+	// the journal so a previous, interrupted run can be resumed.
+	records, err := getRecords(*file, journal)
+	if err != nil {
+		logger.WithError(err).Fatal("could not read input file")
+	}
+
+	var resumed int
+	var pending []*record
+	for _, r := range records {
+		if r.Resumed {
+			resumed++
+			continue
+		}
+		pending = append(pending, r)
+	}
+	records = pending
+	logger.WithField("resumed_from_journal", resumed).Info("merged journal")
+
+	// restrict to an operator-chosen subset of FXIDs, if any were given.
+	records, fst, err := filterByFXID(records)
+	if err != nil {
+		logger.WithError(err).Fatal("could not apply fxid filters")
+	}
 
 	// skip records that have been overwriten, are in trashbin or are versions.
 	newRecords, sk := skipRecords(records)
 
+	// path-prefix can only be applied once skipRecords has resolved each
+	// FXID to its current EOS path.
+	newRecords = filterByPathPrefix(newRecords, fst)
+
 	// get chunked records and nasty records
 	// chunked: the size is a multiple of a owncloud chunk of 10MB
 	// nasty: the size is NOT a multiple of a owncloud chunk of 10MB.
@@ -134,12 +224,20 @@ func main() {
 
 	chunked, nasty = analyze(chunked, nasty)
 
-	fmt.Printf("total=%d to_analize=%d skip_recycle=%d skip_version=%d skip_atomic=%d\n",
-		len(records), len(newRecords), sk.Recycle, sk.Versions, sk.Atomic)
+	logger.WithFields(logrus.Fields{
+		"total":              len(records),
+		"to_analize":         len(newRecords),
+		"skip_recycle":       sk.Recycle,
+		"skip_version":       sk.Versions,
+		"skip_atomic":        sk.Atomic,
+		"filter_whitelist":   fst.Whitelisted,
+		"filter_blacklist":   fst.Blacklisted,
+		"filter_path_prefix": fst.PathPrefix,
+	}).Info("summary")
 
 	count("Automatic reparable records with valid version", chunked)
 	count("Nasty records, need manual repair with backup/recycle", nasty)
-	fmt.Println("Nasty record classification")
+	logger.Info("Nasty record classification")
 	countNasty(nasty, nastyInvalidVersion)
 	countNasty(nasty, nastyNoVersions)
 	countNasty(nasty, nastyNotChunk)
@@ -148,7 +246,18 @@ func main() {
 	analyzeNasty(nasty)
 	printInvalid(nasty)
 
-	rollback(chunked)
+	// nastyNoVersions/nastyInvalidVersion records have no automatic version
+	// to roll back to, but may still be recoverable from recycle; feed them
+	// into the same recovery dispatcher as the chunked records.
+	var recoverable []*record
+	for _, r := range nasty {
+		if r.Status == nastyNoVersions || r.Status == nastyInvalidVersion {
+			recoverable = append(recoverable, r)
+		}
+	}
+
+	toRecover := append(append([]*record{}, chunked...), recoverable...)
+	rollback(toRecover, journal)
 
 }
 
@@ -158,7 +267,11 @@ func analyzeNasty(nasty []*record) {
 		r.Handled = true
 		versions, err := client.ListVersions(ctx, *user, *group, r.File)
 		if err != nil {
-			log.Fatal(err)
+			r.Err = err
+			logger.WithFields(logrus.Fields{
+				"fxid": r.FXID, "file": r.File, "action": "list_versions",
+			}).WithError(err).Error("error listing versions for nasty record")
+			continue
 		}
 
 		r.Versions = versions // add versions to the record
@@ -167,7 +280,9 @@ func analyzeNasty(nasty []*record) {
 
 func printInvalid(nasty []*record) {
 	for _, r := range nasty {
-		fmt.Println(r)
+		logger.WithFields(logrus.Fields{
+			"fxid": r.FXID, "file": r.File, "size": r.Size, "status": r.Status.String(),
+		}).Warn("nasty record")
 	}
 }
 func countNasty(nasty []*record, s status) {
@@ -177,17 +292,84 @@ func countNasty(nasty []*record, s status) {
 			c++
 		}
 	}
-	fmt.Printf("%s: %d\n", s.String(), c)
+	logger.WithFields(logrus.Fields{"status": s.String(), "count": c}).Info("nasty record classification count")
 }
 
-func rollback(chunked []*record) {
+// rollback dispatches each record to the eosclient call appropriate for its
+// RecoveryPlan: roll back to a version, restore from recycle, or leave it
+// for a human when neither is available.
+func rollback(records []*record, j *Journal) {
 	client := getEosClient()
-	for i, r := range chunked {
-		fmt.Printf("dry-run=%t rollback (%d/len(%d): file=%s version=%s\n", !*repair, i, len(chunked), r.File, path.Base(r.ValidVersion.File))
-		if *repair {
-			if err := client.RollbackToVersion(ctx, *user, *group, r.File, path.Base(r.ValidVersion.File)); err != nil {
-				fmt.Printf("error rollbacking %s: %s\n", r, err)
+	order := parseRecoveryOrder(*recoveryOrder)
+
+	// list the recycle bin once for the whole run, not once per record.
+	recycleIndex := map[string]*eosclient.DeletedEntry{}
+	for _, source := range order {
+		if source != "recycle" {
+			continue
+		}
+		idx, err := buildRecycleIndex(client)
+		if err != nil {
+			logger.WithError(err).Error("error listing recycle entries, recycle recovery disabled for this run")
+			break
+		}
+		recycleIndex = idx
+		break
+	}
+
+	var mu sync.Mutex
+	var plans []*Plan
+
+	wq := NewWorkQueue(*workers, *concurrency, *rateLimit, func(r *record) error {
+		plan := planRecovery(r, order, recycleIndex)
+		fields := logrus.Fields{
+			"fxid": r.FXID, "file": r.File, "size": r.Size,
+			"action": plan.Action.String(), "dry_run": !*repair,
+		}
+		logger.WithFields(fields).Info("recovery plan")
+
+		mu.Lock()
+		plans = append(plans, newPlan(r, plan))
+		mu.Unlock()
+
+		if !*repair || plan.Action == Manual {
+			if plan.Action == Manual {
+				logger.WithFields(fields).Warn("no automatic recovery path, needs manual repair")
 			}
+			return nil
+		}
+
+		r.RollbackAttempted = true
+		var err error
+		switch plan.Action {
+		case RollbackVersion:
+			err = client.RollbackToVersion(ctx, *user, *group, r.File, path.Base(r.ValidVersion.File))
+		case RestoreRecycle:
+			err = client.RestoreDeletedEntry(ctx, *user, *group, plan.RecycleEntry.RestoreKey)
+		}
+		if err != nil {
+			r.Err = err
+			logger.WithFields(fields).WithError(err).Error("error recovering record")
+			j.Record(r)
+			return err
+		}
+
+		r.RollbackSucceeded = true
+		j.Record(r)
+		return nil
+	})
+	wq.Run(records)
+
+	processed, failed := wq.Progress()
+	logger.WithFields(logrus.Fields{"processed": processed, "failed": failed}).Info("rollback done")
+
+	if err := writePlans(plans, *output); err != nil {
+		logger.WithError(err).Error("error writing plan output")
+	}
+
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, buildReport(plans)); err != nil {
+			logger.WithError(err).Error("error writing report")
 		}
 	}
 }
@@ -195,15 +377,23 @@ func rollback(chunked []*record) {
 func analyze(chunked, nasty []*record) ([]*record, []*record) {
 	var newchunked []*record
 	client := getEosClient()
-	// go over all chunked records and try and classify them
-	for i, r := range chunked {
-		fmt.Printf("analyzing chunked records (%d/len(%d): %s\n", i, len(chunked), r)
+	var mu sync.Mutex
+
+	// go over all chunked records and try and classify them, fanning the
+	// ListVersions calls out across the worker pool.
+	wq := NewWorkQueue(*workers, *concurrency, *rateLimit, func(r *record) error {
+		fields := logrus.Fields{"fxid": r.FXID, "file": r.File, "size": r.Size, "action": "list_versions"}
 		versions, err := client.ListVersions(ctx, *user, *group, r.File)
 		if err != nil {
-			log.Fatal(err)
+			r.Err = err
+			logger.WithFields(fields).WithError(err).Error("error listing versions")
+			return err
 		}
 
 		r.Versions = versions // add versions to the record
+
+		mu.Lock()
+		defer mu.Unlock()
 		if len(r.Versions) == 0 {
 			r.Status = nastyNoVersions
 			nasty = append(nasty, r)
@@ -215,7 +405,13 @@ func analyze(chunked, nasty []*record) ([]*record, []*record) {
 				nasty = append(nasty, r)
 			}
 		}
-	}
+		return nil
+	})
+	wq.Run(chunked)
+
+	processed, failed := wq.Progress()
+	logger.WithFields(logrus.Fields{"processed": processed, "failed": failed}).Info("analyze done")
+
 	return newchunked, nasty
 }
 
@@ -259,36 +455,39 @@ func count(msg string, records []*record) {
 			c++
 		}
 	}
-	fmt.Printf("%s: %d\n", msg, c)
+	logger.WithField("count", c).Info(msg)
 }
 
 func printRecords(records []*record) {
 	for _, r := range records {
-		fmt.Printf("%d %s %s\n", r.Size, r.Date, r.File)
+		logger.WithFields(logrus.Fields{"size": r.Size, "file": r.File}).Info(r.Date)
 	}
 }
 
-func getRecords(file string) (records []*record) {
+func getRecords(file string, j *Journal) (records []*record, err error) {
 	// read file
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	r := csv.NewReader(strings.NewReader(string(data)))
-	r.Comma = ' ' // file is space separated
+	csvr := csv.NewReader(strings.NewReader(string(data)))
+	csvr.Comma = ' ' // file is space separated
 	for {
-		each, err := r.Read()
+		each, err := csvr.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
-		// validate that we get only  two fields, else abort
+		// a malformed line is logged and skipped rather than aborting the
+		// whole batch: one bad record in a multi-million-line CSV shouldn't
+		// stop the rest from being processed.
 		if len(each) != 2 {
-			log.Fatal(fmt.Sprintf("error: record is invalid: %s", err))
+			logger.WithField("line", each).Warn("skipping malformed record: want exactly two fields")
+			continue
 		}
 
 		r := &record{FXID: strings.TrimSpace(each[1])}
@@ -296,22 +495,28 @@ func getRecords(file string) (records []*record) {
 		// parse date
 		i, err := strconv.ParseInt(each[0], 10, 64)
 		if err != nil {
-			log.Fatal(fmt.Sprintf("error: record is invalid: %s", err))
+			logger.WithField("line", each).WithError(err).Warn("skipping malformed record: invalid mtime")
+			continue
 		}
-
-		t := time.Unix(i, 0)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("error: record size is not an uint64: %s", err))
+		r.Date = time.Unix(i, 0)
+
+		// merge with the journal: a record whose rollback already
+		// succeeded in a previous run is marked Resumed so main can skip
+		// redoing the work.
+		if entry, ok := j.Get(r.FXID); ok {
+			r.Status = entry.Status
+			r.RollbackAttempted = entry.RollbackAttempted
+			r.RollbackSucceeded = entry.RollbackSucceeded
+			r.Resumed = entry.RollbackSucceeded
 		}
-		r.Date = t
 
 		records = append(records, r)
 	}
-	return
+	return records, nil
 }
 
 func getRecordDistribution(records []*record) (chunked, nasty []*record) {
-	for i, r := range records {
+	for _, r := range records {
 		if isChunked(r.Size) {
 			chunked = append(chunked, r)
 		} else {
@@ -319,17 +524,17 @@ func getRecordDistribution(records []*record) (chunked, nasty []*record) {
 			nasty = append(nasty, r)
 		}
 		r.Handled = true
-		fmt.Printf("processing records (%d/len(%d): %s\n", i, len(chunked), r)
+		logger.WithFields(logrus.Fields{
+			"fxid": r.FXID, "file": r.File, "size": r.Size, "action": "distribute",
+		}).Debug("processing record")
 	}
 	return
 }
 
-// chunks is ownCloud are 10MB
+// isChunked consults the configured chunkPolicy to decide whether size looks
+// like a fragment of an aborted chunked upload.
 func isChunked(size uint64) bool {
-	if size%(10*1000000) == 0 { // 10MB {}
-		return true
-	}
-	return false
+	return chunkPolicy.IsChunked(size)
 }
 
 // a record parses an input file like this one:
@@ -343,6 +548,11 @@ type record struct {
 	Status       status                // the status of the record, check top of the file for definition.
 	Versions     []*eosclient.FileInfo // available versions for this record if any.
 	ValidVersion *eosclient.FileInfo   // the version key of a valid version to rollback.
+	Err          error                 // error encountered while handling this record, if any.
+
+	RollbackAttempted bool // whether rollback has been attempted against the MGM, per the journal.
+	RollbackSucceeded bool // whether that rollback attempt succeeded, per the journal.
+	Resumed           bool // true if the journal already shows RollbackSucceeded for this record.
 }
 
 func (r *record) String() string {
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cs3org/reva/pkg/eosclient"
+)
+
+// RecoveryAction is the concrete action rollback will take for a given
+// record, chosen per-record by planRecovery.
+type RecoveryAction int
+
+const (
+	// RollbackVersion reverts the current file to r.ValidVersion.
+	RollbackVersion RecoveryAction = iota
+	// RestoreRecycle restores the record from EOS's /proc/recycle bin.
+	RestoreRecycle
+	// Manual means no automatic recovery path was found; an operator has
+	// to look at the record by hand.
+	Manual
+)
+
+func (a RecoveryAction) String() string {
+	switch a {
+	case RollbackVersion:
+		return "rollback_version"
+	case RestoreRecycle:
+		return "restore_recycle"
+	case Manual:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+// RecoveryPlan is the per-record decision of how to recover a nasty record:
+// roll back to a version, restore from recycle, or fall back to a manual
+// repair.
+type RecoveryPlan struct {
+	Action       RecoveryAction
+	RecycleEntry *eosclient.DeletedEntry // set when Action == RestoreRecycle
+}
+
+// parseRecoveryOrder parses -recovery-order (a comma-separated list of
+// "version","recycle") into a preference order. Unknown tokens are dropped
+// rather than aborting the run; the order defaults to version-then-recycle
+// if nothing valid was given.
+func parseRecoveryOrder(s string) []string {
+	var order []string
+	for _, tok := range strings.Split(s, ",") {
+		tok = strings.TrimSpace(tok)
+		if tok == "version" || tok == "recycle" {
+			order = append(order, tok)
+		}
+	}
+	if len(order) == 0 {
+		order = []string{"version", "recycle"}
+	}
+	return order
+}
+
+// buildRecycleIndex lists the MGM recycle bin once and indexes the newest
+// entry per RestorePath. Listing the whole recycle bin is expensive, so
+// rollback calls this a single time per run rather than once per record -
+// doing it per record would defeat the WorkQueue worker pool/rate-limiter
+// that exists to avoid overloading the MGM. When a RestorePath has been
+// deleted/recreated more than once, the most recently deleted entry wins,
+// same newest-first convention sortVersions uses for regular versions.
+func buildRecycleIndex(client *eosclient.Client) (map[string]*eosclient.DeletedEntry, error) {
+	entries, err := client.ListDeletedEntries(ctx, *user, *group)
+	if err != nil {
+		return nil, err
+	}
+	return newestByRestorePath(entries), nil
+}
+
+// newestByRestorePath indexes entries by RestorePath, keeping the one with
+// the largest DeletionMTimeSec when a path has been deleted more than once.
+func newestByRestorePath(entries []*eosclient.DeletedEntry) map[string]*eosclient.DeletedEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletionMTimeSec > entries[j].DeletionMTimeSec
+	})
+
+	index := map[string]*eosclient.DeletedEntry{}
+	for _, e := range entries {
+		if _, ok := index[e.RestorePath]; !ok {
+			index[e.RestorePath] = e
+		}
+	}
+	return index
+}
+
+// planRecovery chooses a RecoveryPlan for r, trying each source in
+// recoveryOrder until one has something to offer. rollback() calls it for
+// the chunked records that have a ValidVersion as well as for the
+// nastyNoVersions/nastyInvalidVersion records, which have no usable version
+// and can only be restored from recycle. recycleIndex is built once per
+// rollback() run by buildRecycleIndex.
+func planRecovery(r *record, recoveryOrder []string, recycleIndex map[string]*eosclient.DeletedEntry) *RecoveryPlan {
+	for _, source := range recoveryOrder {
+		switch source {
+		case "version":
+			if r.ValidVersion != nil {
+				return &RecoveryPlan{Action: RollbackVersion}
+			}
+
+		case "recycle":
+			if e, ok := recycleIndex[r.File]; ok {
+				return &RecoveryPlan{Action: RestoreRecycle, RecycleEntry: e}
+			}
+		}
+	}
+	return &RecoveryPlan{Action: Manual}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cs3org/reva/pkg/eosclient"
+)
+
+func TestParseRecoveryOrder(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"version,recycle", []string{"version", "recycle"}},
+		{"recycle, version", []string{"recycle", "version"}},
+		{"bogus", []string{"version", "recycle"}},
+		{"", []string{"version", "recycle"}},
+		{"version,bogus,recycle", []string{"version", "recycle"}},
+	}
+	for _, c := range cases {
+		if got := parseRecoveryOrder(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseRecoveryOrder(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestNewestByRestorePath(t *testing.T) {
+	old := &eosclient.DeletedEntry{RestorePath: "/a", DeletionMTimeSec: 100}
+	newer := &eosclient.DeletedEntry{RestorePath: "/a", DeletionMTimeSec: 200}
+	other := &eosclient.DeletedEntry{RestorePath: "/b", DeletionMTimeSec: 150}
+
+	index := newestByRestorePath([]*eosclient.DeletedEntry{old, newer, other})
+
+	if index["/a"] != newer {
+		t.Errorf("expected the newest entry for /a to win, got %+v", index["/a"])
+	}
+	if index["/b"] != other {
+		t.Errorf("expected /b to map to its only entry, got %+v", index["/b"])
+	}
+	if len(index) != 2 {
+		t.Errorf("expected 2 distinct paths, got %d", len(index))
+	}
+}
+
+func TestPlanRecovery(t *testing.T) {
+	withVersion := &record{ValidVersion: &eosclient.FileInfo{File: "/v1"}}
+	if plan := planRecovery(withVersion, []string{"version", "recycle"}, nil); plan.Action != RollbackVersion {
+		t.Errorf("expected RollbackVersion when ValidVersion is set, got %v", plan.Action)
+	}
+
+	recycleIndex := map[string]*eosclient.DeletedEntry{"/f": {RestorePath: "/f"}}
+	noVersion := &record{File: "/f"}
+	if plan := planRecovery(noVersion, []string{"version", "recycle"}, recycleIndex); plan.Action != RestoreRecycle {
+		t.Errorf("expected RestoreRecycle when recycle has a match, got %v", plan.Action)
+	}
+
+	unmatched := &record{File: "/missing"}
+	if plan := planRecovery(unmatched, []string{"version", "recycle"}, recycleIndex); plan.Action != Manual {
+		t.Errorf("expected Manual when no source matches, got %v", plan.Action)
+	}
+}
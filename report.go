@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Plan is the structured, machine-readable description of what rollback
+// would do (or did) for a single record. It's what -output=json|csv|text
+// renders instead of the free-form lines -repair=false used to print,
+// so a dry run is safe to hand to someone other than the tool's author.
+type Plan struct {
+	FXID               string `json:"fxid"`
+	File               string `json:"file"`
+	CurrentSize        uint64 `json:"current_size"`
+	CurrentMTime       string `json:"current_mtime"`
+	ChosenVersionFile  string `json:"chosen_version_file,omitempty"`
+	ChosenVersionSize  uint64 `json:"chosen_version_size,omitempty"`
+	ChosenVersionMTime string `json:"chosen_version_mtime,omitempty"`
+	Action             string `json:"action"`
+	Reason             string `json:"reason"`
+}
+
+// newPlan builds the Plan for r given the RecoveryPlan chosen for it. The
+// chosen_version_* fields describe whatever rollback would actually use to
+// recover the file: a prior version for RollbackVersion, or the matching
+// recycle entry for RestoreRecycle - without this, a RestoreRecycle plan
+// would show no indication of what would be restored.
+func newPlan(r *record, plan *RecoveryPlan) *Plan {
+	p := &Plan{
+		FXID:         r.FXID,
+		File:         r.File,
+		CurrentSize:  r.Size,
+		CurrentMTime: r.Date.Format(time.RFC3339),
+		Action:       plan.Action.String(),
+		Reason:       r.Status.String(),
+	}
+
+	switch {
+	case plan.Action == RollbackVersion && r.ValidVersion != nil:
+		p.ChosenVersionFile = r.ValidVersion.File
+		p.ChosenVersionSize = r.ValidVersion.Size
+		p.ChosenVersionMTime = time.Unix(int64(r.ValidVersion.MTimeSec), 0).Format(time.RFC3339)
+
+	case plan.Action == RestoreRecycle && plan.RecycleEntry != nil:
+		p.ChosenVersionFile = plan.RecycleEntry.RestoreKey
+		p.ChosenVersionSize = plan.RecycleEntry.Size
+		p.ChosenVersionMTime = time.Unix(int64(plan.RecycleEntry.DeletionMTimeSec), 0).Format(time.RFC3339)
+	}
+	return p
+}
+
+// writePlans renders plans to stdout in the given -output format.
+func writePlans(plans []*Plan, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		for _, p := range plans {
+			if err := enc.Encode(p); err != nil {
+				return err
+			}
+		}
+
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+		if err := w.Write([]string{
+			"fxid", "file", "current_size", "current_mtime",
+			"chosen_version_file", "chosen_version_size", "chosen_version_mtime",
+			"action", "reason",
+		}); err != nil {
+			return err
+		}
+		for _, p := range plans {
+			row := []string{
+				p.FXID, p.File, fmt.Sprintf("%d", p.CurrentSize), p.CurrentMTime,
+				p.ChosenVersionFile, fmt.Sprintf("%d", p.ChosenVersionSize), p.ChosenVersionMTime,
+				p.Action, p.Reason,
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+
+	case "text", "":
+		for _, p := range plans {
+			fmt.Printf("fxid=%s file=%s current_size=%d current_mtime=%s chosen_version_file=%s chosen_version_size=%d chosen_version_mtime=%s action=%s reason=%s\n",
+				p.FXID, p.File, p.CurrentSize, p.CurrentMTime,
+				p.ChosenVersionFile, p.ChosenVersionSize, p.ChosenVersionMTime, p.Action, p.Reason)
+		}
+
+	default:
+		return fmt.Errorf("unknown -output %q, want json, csv or text", format)
+	}
+	return nil
+}
+
+// Report summarizes a batch of plans: counts by action, total bytes that
+// would be reverted, and the oldest/newest chosen version, so operators can
+// review a dry run before re-running with -repair.
+type Report struct {
+	CountByAction map[string]int `json:"count_by_action"`
+	TotalBytes    uint64         `json:"total_bytes_to_revert"`
+	OldestVersion string         `json:"oldest_chosen_version,omitempty"`
+	NewestVersion string         `json:"newest_chosen_version,omitempty"`
+}
+
+func buildReport(plans []*Plan) *Report {
+	rep := &Report{CountByAction: map[string]int{}}
+	var oldest, newest time.Time
+
+	for _, p := range plans {
+		rep.CountByAction[p.Action]++
+		if p.ChosenVersionFile == "" {
+			continue
+		}
+		rep.TotalBytes += p.ChosenVersionSize
+
+		t, err := time.Parse(time.RFC3339, p.ChosenVersionMTime)
+		if err != nil {
+			continue
+		}
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+			rep.OldestVersion = p.ChosenVersionFile
+		}
+		if newest.IsZero() || t.After(newest) {
+			newest = t
+			rep.NewestVersion = p.ChosenVersionFile
+		}
+	}
+	return rep
+}
+
+// writeReport writes rep as indented JSON to path.
+func writeReport(path string, rep *Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
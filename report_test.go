@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cs3org/reva/pkg/eosclient"
+)
+
+func TestNewPlanRollbackVersion(t *testing.T) {
+	r := &record{
+		FXID:         "aaaa",
+		File:         "/eos/user/a/foo",
+		Size:         42,
+		Date:         time.Unix(1000, 0),
+		Status:       repairable,
+		ValidVersion: &eosclient.FileInfo{File: "/eos/user/a/.sys.v#.foo/0", Size: 41, MTimeSec: 900},
+	}
+	plan := &RecoveryPlan{Action: RollbackVersion}
+
+	p := newPlan(r, plan)
+	if p.ChosenVersionFile != r.ValidVersion.File || p.ChosenVersionSize != r.ValidVersion.Size {
+		t.Errorf("expected chosen version fields to come from ValidVersion, got %+v", p)
+	}
+}
+
+func TestNewPlanRestoreRecycle(t *testing.T) {
+	r := &record{FXID: "bbbb", File: "/eos/user/a/bar", Status: nastyNoVersions}
+	plan := &RecoveryPlan{
+		Action:       RestoreRecycle,
+		RecycleEntry: &eosclient.DeletedEntry{RestoreKey: "recycle-key", Size: 99, DeletionMTimeSec: 500},
+	}
+
+	p := newPlan(r, plan)
+	if p.ChosenVersionFile != "recycle-key" || p.ChosenVersionSize != 99 {
+		t.Errorf("expected chosen version fields to come from RecycleEntry, got %+v", p)
+	}
+}
+
+func TestNewPlanManual(t *testing.T) {
+	r := &record{FXID: "cccc"}
+	p := newPlan(r, &RecoveryPlan{Action: Manual})
+	if p.ChosenVersionFile != "" {
+		t.Errorf("expected no chosen version for a manual plan, got %+v", p)
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	plans := []*Plan{
+		{Action: "rollback_version", ChosenVersionFile: "/v1", ChosenVersionSize: 10, ChosenVersionMTime: time.Unix(100, 0).Format(time.RFC3339)},
+		{Action: "rollback_version", ChosenVersionFile: "/v2", ChosenVersionSize: 20, ChosenVersionMTime: time.Unix(300, 0).Format(time.RFC3339)},
+		{Action: "manual"},
+	}
+
+	rep := buildReport(plans)
+	if rep.CountByAction["rollback_version"] != 2 || rep.CountByAction["manual"] != 1 {
+		t.Errorf("unexpected CountByAction: %+v", rep.CountByAction)
+	}
+	if rep.TotalBytes != 30 {
+		t.Errorf("expected TotalBytes 30, got %d", rep.TotalBytes)
+	}
+	if rep.OldestVersion != "/v1" || rep.NewestVersion != "/v2" {
+		t.Errorf("expected oldest/newest /v1 and /v2, got %s/%s", rep.OldestVersion, rep.NewestVersion)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// WorkQueue fans a slice of records out to a bounded pool of worker
+// goroutines. It exists because running GetFileInfoByFXID/ListVersions/
+// RollbackToVersion serially against the MGM is prohibitive once the input
+// CSV holds millions of records.
+type WorkQueue struct {
+	workers     int
+	concurrency int
+	ratePerSec  float64
+	fn          func(*record) error
+
+	mu              sync.Mutex
+	processed       int
+	failed          int
+	workerProcessed []int
+	workerFailed    []int
+}
+
+// NewWorkQueue builds a WorkQueue that will call fn once per record handed
+// to Run. workers is the number of goroutines pulling records off the
+// internal channel; concurrency further caps how many calls to fn may be
+// in flight at once (it defaults to workers when <= 0). ratePerSec, when
+// greater than zero, throttles fn to at most that many calls per second so
+// a batch run doesn't overload the MGM.
+func NewWorkQueue(workers, concurrency int, ratePerSec float64, fn func(*record) error) *WorkQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	if concurrency < 1 {
+		concurrency = workers
+	}
+	return &WorkQueue{
+		workers:         workers,
+		concurrency:     concurrency,
+		ratePerSec:      ratePerSec,
+		fn:              fn,
+		workerProcessed: make([]int, workers),
+		workerFailed:    make([]int, workers),
+	}
+}
+
+// Run dispatches records to q.workers goroutines and blocks until every
+// record has been passed to fn, or, once ctx is cancelled, until the
+// records already handed out are done. Per-record errors are the responsibility of
+// fn (it should store them on the record); Run itself never aborts the
+// batch and never calls log.Fatal.
+func (q *WorkQueue) Run(records []*record) {
+	jobs := make(chan *record)
+	sem := make(chan struct{}, q.concurrency)
+
+	var limiter *time.Ticker
+	if q.ratePerSec > 0 {
+		limiter = time.NewTicker(time.Duration(float64(time.Second) / q.ratePerSec))
+		defer limiter.Stop()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for r := range jobs {
+				sem <- struct{}{}
+				if limiter != nil {
+					<-limiter.C
+				}
+				err := q.fn(r)
+				<-sem
+
+				q.mu.Lock()
+				q.processed++
+				q.workerProcessed[id]++
+				if err != nil {
+					q.failed++
+					q.workerFailed[id]++
+				}
+				q.mu.Unlock()
+			}
+		}(i)
+	}
+
+	// stop handing out new work as soon as ctx is cancelled (e.g. on
+	// SIGINT); workers drain whatever they're already holding and Run
+	// returns once they do, so the journal stays consistent.
+feed:
+	for _, r := range records {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- r:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Progress reports how many records have been handed to fn so far and how
+// many of those returned an error, aggregated across all workers.
+func (q *WorkQueue) Progress() (processed, failed int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.processed, q.failed
+}
+
+// WorkerProgress reports processed/failed counts per worker goroutine,
+// indexed by worker id (0..workers-1).
+func (q *WorkQueue) WorkerProgress() (processed, failed []int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]int(nil), q.workerProcessed...), append([]int(nil), q.workerFailed...)
+}